@@ -21,11 +21,17 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
+	"net/http"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -83,3 +89,895 @@ func testMakeBucket() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test creating an already existing bucket, by the owner and by another client
+func testCreateBucketIdempotency() {
+	startTime := time.Now()
+	function := "testCreateBucketIdempotency"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	// Creating the bucket again as its owner either succeeds or reports BucketAlreadyOwnedByYou
+	_, err = s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != "BucketAlreadyOwnedByYou" {
+			failureLog(function, args, startTime, "", "CreateBucket by owner returned unexpected error", err).Fatal()
+			return
+		}
+	}
+
+	// A different owner needs a second valid identity
+	if secondCredentials == nil {
+		skipLog(function, args, startTime, "SECOND_ACCESS_KEY and SECOND_SECRET_KEY are required for the second owner").Info()
+		return
+	}
+	newSession, err := session.NewSession()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("NewSession expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	s3Config := s3Client.Config
+	s3Config.Credentials = secondCredentials
+	s3ClientTest := s3.New(newSession, &s3Config)
+
+	_, err = s3ClientTest.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "CreateBucket by second client expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		failureLog(function, args, startTime, "", "CreateBucket by second client returned unexpected error", err).Fatal()
+		return
+	}
+	switch aerr.Code() {
+	case "BucketAlreadyExists", "AccessDenied":
+	default:
+		failureLog(function, args, startTime, "", "CreateBucket by second client returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test concurrently creating and deleting many buckets
+func testBucketChurn() {
+	startTime := time.Now()
+	function := "testBucketChurn"
+	bucketPrefix := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-churn-")
+	bucketCount := 50
+	concurrency := 8
+	args := map[string]interface{}{
+		"bucketPrefix": bucketPrefix,
+		"bucketCount":  bucketCount,
+		"concurrency":  concurrency,
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < bucketCount; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(bucket string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+				Bucket: aws.String(bucket),
+			})
+			if err == nil {
+				_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{
+					Bucket: aws.String(bucket),
+				})
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", bucket, err))
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("%s-%d", bucketPrefix, i))
+	}
+	wg.Wait()
+
+	// Buckets left behind by the churn, removed before failing the test
+	leftoverBuckets := func() ([]string, error) {
+		result, err := s3Client.ListBuckets(&s3.ListBucketsInput{})
+		if err != nil {
+			return nil, err
+		}
+		var buckets []string
+		for _, bucket := range result.Buckets {
+			if strings.HasPrefix(aws.StringValue(bucket.Name), bucketPrefix) {
+				buckets = append(buckets, aws.StringValue(bucket.Name))
+			}
+		}
+		return buckets, nil
+	}
+
+	if len(errs) > 0 {
+		args["errors"] = len(errs)
+		if buckets, err := leftoverBuckets(); err == nil {
+			for _, bucket := range buckets {
+				cleanupBucket(bucket, function, args, startTime)
+			}
+		}
+		failureLog(function, args, startTime, "", "CreateBucket/DeleteBucket failed", errs[0]).Fatal()
+		return
+	}
+
+	buckets, err := leftoverBuckets()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListBuckets expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(buckets) > 0 {
+		for _, bucket := range buckets {
+			cleanupBucket(bucket, function, args, startTime)
+		}
+		failureLog(function, args, startTime, "", "ListBuckets returned a deleted bucket", fmt.Errorf("unexpected bucket %s", buckets[0])).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test applying a lifecycle configuration to an object lock enabled bucket.
+// The object lock token is only required by PutObjectLockConfiguration, so
+// the lifecycle request is sent without it and must succeed.
+func testLifecycleOnLockBucket() {
+	startTime := time.Now()
+	function := "testLifecycleOnLockBucket"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	lifecycle := &s3.BucketLifecycleConfiguration{
+		Rules: []*s3.LifecycleRule{
+			{
+				ID:         aws.String("expire-prefix"),
+				Status:     aws.String("Enabled"),
+				Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("prefix/")},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+				NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{
+					NoncurrentDays: aws.Int64(1),
+				},
+			},
+		},
+	}
+	args["lifecycle"] = lifecycle
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: lifecycle,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(result.Rules) != 1 || aws.StringValue(result.Rules[0].ID) != "expire-prefix" {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected rules", nil).Fatal()
+		return
+	}
+
+	// Object lock configuration must be unaffected by the lifecycle rule
+	lockConfig, err := s3Client.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObjectLockConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if lockConfig.ObjectLockConfiguration == nil || aws.StringValue(lockConfig.ObjectLockConfiguration.ObjectLockEnabled) != "Enabled" {
+		failureLog(function, args, startTime, "", "Object lock is no longer enabled after applying lifecycle", nil).Fatal()
+		return
+	}
+
+	_, err = s3Client.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("DeleteBucketLifecycle expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a lifecycle transition to an unknown tier is rejected up front
+func testInvalidTransitionStorageClass() {
+	startTime := time.Now()
+	function := "testInvalidTransitionStorageClass"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	storageClass := strings.ToUpper(randString(20, rand.NewSource(time.Now().UnixNano()), "missing-tier-"))
+	args := map[string]interface{}{
+		"bucketName":   bucket,
+		"storageClass": storageClass,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:     aws.String("transition-missing-tier"),
+					Status: aws.String("Enabled"),
+					Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+					Transitions: []*s3.Transition{
+						{Days: aws.Int64(1), StorageClass: aws.String(storageClass)},
+					},
+				},
+			},
+		},
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "PutBucketLifecycleConfiguration with an unknown storage class expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		failureLog(function, args, startTime, "", "PutBucketLifecycleConfiguration returned unexpected error", err).Fatal()
+		return
+	}
+	if aerr.Code() == "NotImplemented" {
+		ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+		return
+	}
+	args["errorCode"] = aerr.Code()
+
+	// The rejected configuration must not have been stored
+	_, err = s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned a rejected configuration", nil).Fatal()
+		return
+	}
+	aerr, ok = err.(awserr.Error)
+	if !ok || aerr.Code() != "NoSuchLifecycleConfiguration" {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that lifecycle transitions missing a storage class or a schedule are rejected
+func testTransitionMissingStorageClass() {
+	startTime := time.Now()
+	function := "testTransitionMissingStorageClass"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	// Transition dates must be at midnight UTC
+	transitionDate := time.Now().UTC().Truncate(24 * time.Hour).Add(48 * time.Hour)
+
+	testCases := []struct {
+		name       string
+		transition *s3.Transition
+	}{
+		{name: "date without storage class", transition: &s3.Transition{Date: aws.Time(transitionDate)}},
+		{name: "storage class without date or days", transition: &s3.Transition{StorageClass: aws.String("WARM-TIER")}},
+	}
+
+	for _, testCase := range testCases {
+		args["case"] = testCase.name
+		_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket: aws.String(bucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+				Rules: []*s3.LifecycleRule{
+					{
+						ID:          aws.String("incomplete-transition"),
+						Status:      aws.String("Enabled"),
+						Filter:      &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+						Transitions: []*s3.Transition{testCase.transition},
+					},
+				},
+			},
+		})
+		if err == nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration with %s expected to fail but succeeded", testCase.name), nil).Fatal()
+			return
+		}
+		aerr, ok := err.(awserr.Error)
+		if ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		if !ok || (aerr.Code() != "MalformedXML" && aerr.Code() != "InvalidArgument") {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration with %s returned unexpected error", testCase.name), err).Fatal()
+			return
+		}
+	}
+	delete(args, "case")
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a lifecycle rule ID with spaces and unicode reads back unchanged.
+// Expiry of matching objects needs the lifecycle scanner and is not checked.
+func testRuleIdEncoding() {
+	startTime := time.Now()
+	function := "testRuleIdEncoding"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	ruleID := "expire rule ✓ ünïcödé 日本語"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"ruleID":     ruleID,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:         aws.String(ruleID),
+					Status:     aws.String("Enabled"),
+					Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("prefix/")},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(result.Rules) != 1 {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected rules", nil).Fatal()
+		return
+	}
+	if gotID := aws.StringValue(result.Rules[0].ID); gotID != ruleID {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned a different rule ID", fmt.Errorf("want %x, got %x", ruleID, gotID)).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a lifecycle filter with both Prefix and And is rejected
+func testConflictingFilterFields() {
+	startTime := time.Now()
+	function := "testConflictingFilterFields"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:     aws.String("conflicting-filter"),
+					Status: aws.String("Enabled"),
+					Filter: &s3.LifecycleRuleFilter{
+						Prefix: aws.String("prefix/"),
+						And: &s3.LifecycleRuleAndOperator{
+							Prefix: aws.String("other/"),
+							Tags:   []*s3.Tag{{Key: aws.String("type"), Value: aws.String("text")}},
+						},
+					},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+				},
+			},
+		},
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "PutBucketLifecycleConfiguration with Prefix and And expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if ok && aerr.Code() == "NotImplemented" {
+		ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+		return
+	}
+	if !ok || aerr.Code() != "MalformedXML" {
+		failureLog(function, args, startTime, "", "PutBucketLifecycleConfiguration with Prefix and And returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a single-tag And filter reads back as the same tag, either
+// unchanged or normalized to a plain Tag filter
+func testFilterNormalization() {
+	startTime := time.Now()
+	function := "testFilterNormalization"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	tag := &s3.Tag{Key: aws.String("type"), Value: aws.String("text")}
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:         aws.String("single-tag-and"),
+					Status:     aws.String("Enabled"),
+					Filter:     &s3.LifecycleRuleFilter{And: &s3.LifecycleRuleAndOperator{Tags: []*s3.Tag{tag}}},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(result.Rules) != 1 || result.Rules[0].Filter == nil {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected rules", nil).Fatal()
+		return
+	}
+
+	sameTag := func(t *s3.Tag) bool {
+		return t != nil && aws.StringValue(t.Key) == aws.StringValue(tag.Key) && aws.StringValue(t.Value) == aws.StringValue(tag.Value)
+	}
+	filter := result.Rules[0].Filter
+	switch {
+	case filter.And != nil && filter.Tag == nil && aws.StringValue(filter.And.Prefix) == "" &&
+		len(filter.And.Tags) == 1 && sameTag(filter.And.Tags[0]):
+		args["filterForm"] = "And"
+	case filter.And == nil && sameTag(filter.Tag) && aws.StringValue(filter.Prefix) == "":
+		args["filterForm"] = "Tag"
+	default:
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned a filter different from the one stored", fmt.Errorf("got %v", filter)).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test reading lifecycle configuration while it is being replaced and removed
+func testLifecycleConfigChurn() {
+	startTime := time.Now()
+	function := "testLifecycleConfigChurn"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	churnDuration := 10 * time.Second
+	readers := 4
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"duration":   churnDuration,
+		"readers":    readers,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	lifecycle := func(days int64) *s3.BucketLifecycleConfiguration {
+		return &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:         aws.String("churn"),
+					Status:     aws.String("Enabled"),
+					Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("prefix/")},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(days)},
+				},
+			},
+		}
+	}
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: lifecycle(1),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		readErrs []error
+		writeErr error
+		writes   int
+	)
+	done := make(chan struct{})
+
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+					Bucket: aws.String(bucket),
+				})
+				if err == nil {
+					if len(result.Rules) == 1 && aws.StringValue(result.Rules[0].ID) == "churn" &&
+						result.Rules[0].Expiration != nil && aws.Int64Value(result.Rules[0].Expiration.Days) > 0 {
+						continue
+					}
+					err = fmt.Errorf("unexpected lifecycle configuration %v", result.Rules)
+				} else if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+					continue
+				}
+				mu.Lock()
+				readErrs = append(readErrs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	churnStart := time.Now()
+	for time.Since(churnStart) < churnDuration {
+		if writes%2 == 0 {
+			_, writeErr = s3Client.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+				Bucket: aws.String(bucket),
+			})
+		} else {
+			_, writeErr = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String(bucket),
+				LifecycleConfiguration: lifecycle(int64(writes%30 + 1)),
+			})
+		}
+		if writeErr != nil {
+			break
+		}
+		writes++
+	}
+	close(done)
+	wg.Wait()
+	args["writes"] = writes
+
+	if writeErr != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Lifecycle configuration update expected to succeed but got %v", writeErr), writeErr).Fatal()
+		return
+	}
+	if len(readErrs) > 0 {
+		args["readErrors"] = len(readErrs)
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned an invalid response while churning", readErrs[0]).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that PUT and HEAD report the matching expiration rule in x-amz-expiration
+func testExpirationHeader() {
+	startTime := time.Now()
+	function := "testExpirationHeader"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	ruleID := "expire-after-one-day"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+		"ruleID":     ruleID,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:         aws.String(ruleID),
+					Status:     aws.String("Enabled"),
+					Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// expiry-date="Fri, 23 Dec 2012 00:00:00 GMT", rule-id="rule"
+	expirationRegex := regexp.MustCompile(`expiry-date="(.+?)", rule-id="(.+?)"`)
+	checkExpiration := func(header string) error {
+		matches := expirationRegex.FindStringSubmatch(header)
+		if matches == nil {
+			return fmt.Errorf("malformed x-amz-expiration %q", header)
+		}
+		if matches[2] != ruleID {
+			return fmt.Errorf("want rule-id %q, got %q", ruleID, matches[2])
+		}
+		expiryDate, err := time.Parse(http.TimeFormat, matches[1])
+		if err != nil {
+			return err
+		}
+		// Expiry is rounded up to the midnight after one day has passed
+		earliest := startTime.UTC().Add(24 * time.Hour)
+		if !expiryDate.Equal(expiryDate.Truncate(24*time.Hour)) ||
+			expiryDate.Before(earliest) || expiryDate.After(earliest.Add(48*time.Hour)) {
+			return fmt.Errorf("unexpected expiry-date %v", expiryDate)
+		}
+		return nil
+	}
+
+	putOutput, err := s3Client.PutObject(&s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if err = checkExpiration(aws.StringValue(putOutput.Expiration)); err != nil {
+		failureLog(function, args, startTime, "", "PUT returned unexpected x-amz-expiration", err).Fatal()
+		return
+	}
+
+	headOutput, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if aws.StringValue(headOutput.Expiration) != aws.StringValue(putOutput.Expiration) {
+		failureLog(function, args, startTime, "", "HEAD returned a different x-amz-expiration than PUT", fmt.Errorf("want %q, got %q", aws.StringValue(putOutput.Expiration), aws.StringValue(headOutput.Expiration))).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test reading back a multi-rule lifecycle configuration and removing it.
+// Transition rules need a remote tier and are not covered here.
+func testGetLifecycleConfiguration() {
+	startTime := time.Now()
+	function := "testGetLifecycleConfiguration"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	rules := []*s3.LifecycleRule{
+		{
+			ID:         aws.String("expire-logs"),
+			Status:     aws.String("Enabled"),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+		},
+		{
+			ID:     aws.String("abort-uploads"),
+			Status: aws.String("Enabled"),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("uploads/")},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int64(7),
+			},
+		},
+	}
+
+	_, err = s3Client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "Lifecycle is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetBucketLifecycleConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(result.Rules) != len(rules) {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected rules", fmt.Errorf("want %d rules, got %d", len(rules), len(result.Rules))).Fatal()
+		return
+	}
+
+	// Servers may reorder rules and move a prefix-only filter to the
+	// deprecated top-level Prefix, so compare the fields that matter
+	prefix := func(rule *s3.LifecycleRule) string {
+		if rule.Filter != nil && rule.Filter.Prefix != nil {
+			return aws.StringValue(rule.Filter.Prefix)
+		}
+		return aws.StringValue(rule.Prefix)
+	}
+	expirationDays := func(rule *s3.LifecycleRule) int64 {
+		if rule.Expiration == nil {
+			return 0
+		}
+		return aws.Int64Value(rule.Expiration.Days)
+	}
+	abortDays := func(rule *s3.LifecycleRule) int64 {
+		if rule.AbortIncompleteMultipartUpload == nil {
+			return 0
+		}
+		return aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	}
+	got := make(map[string]*s3.LifecycleRule, len(result.Rules))
+	for _, rule := range result.Rules {
+		got[aws.StringValue(rule.ID)] = rule
+	}
+	for _, want := range rules {
+		rule, ok := got[aws.StringValue(want.ID)]
+		if !ok || aws.StringValue(rule.Status) != aws.StringValue(want.Status) || prefix(rule) != prefix(want) ||
+			expirationDays(rule) != expirationDays(want) || abortDays(rule) != abortDays(want) {
+			failureLog(function, args, startTime, "", fmt.Sprintf("GetBucketLifecycleConfiguration returned unexpected rule %s", aws.StringValue(want.ID)), fmt.Errorf("got %v", rule)).Fatal()
+			return
+		}
+	}
+
+	_, err = s3Client.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("DeleteBucketLifecycle expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	_, err = s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration expected to fail after DeleteBucketLifecycle but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != "NoSuchLifecycleConfiguration" {
+		failureLog(function, args, startTime, "", "GetBucketLifecycleConfiguration returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
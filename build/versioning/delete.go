@@ -297,3 +297,227 @@ func testDeleteObjects() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test multi delete API reporting retained versions as per-object errors
+func testDeleteObjectsPartialFailure() {
+	startTime := time.Now()
+	function := "testDeleteObjectsPartialFailure"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	type uploadedObject struct {
+		name      string
+		retention string
+		versionId string
+	}
+
+	uploads := []uploadedObject{
+		{name: "free-1"},
+		{name: "retained-1", retention: "GOVERNANCE"},
+		{name: "free-2"},
+		{name: "retained-2", retention: "GOVERNANCE"},
+	}
+
+	for i := range uploads {
+		putInput := &s3.PutObjectInput{
+			Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+			Bucket: aws.String(bucket),
+			Key:    aws.String(uploads[i].name),
+		}
+		if uploads[i].retention != "" {
+			putInput.ObjectLockMode = aws.String(uploads[i].retention)
+			putInput.ObjectLockRetainUntilDate = aws.Time(time.Now().UTC().Add(time.Hour))
+		}
+		output, err := s3Client.PutObject(putInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		uploads[i].versionId = *output.VersionId
+	}
+
+	del := &s3.Delete{}
+	for i := range uploads {
+		del.Objects = append(del.Objects, &s3.ObjectIdentifier{
+			Key:       aws.String(uploads[i].name),
+			VersionId: aws.String(uploads[i].versionId),
+		})
+	}
+	deleteOutput, err := s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: del,
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Delete expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	deleted := make(map[string]bool)
+	for _, d := range deleteOutput.Deleted {
+		deleted[aws.StringValue(d.Key)] = true
+	}
+	failed := make(map[string]string)
+	for _, e := range deleteOutput.Errors {
+		failed[aws.StringValue(e.Key)] = aws.StringValue(e.Code)
+	}
+
+	for _, upload := range uploads {
+		if upload.retention == "" {
+			if !deleted[upload.name] {
+				failureLog(function, args, startTime, "", fmt.Sprintf("DeleteObjects did not report %s as deleted", upload.name), nil).Fatal()
+				return
+			}
+			continue
+		}
+		code, ok := failed[upload.name]
+		if !ok {
+			failureLog(function, args, startTime, "", fmt.Sprintf("DeleteObjects did not report an error for %s", upload.name), nil).Fatal()
+			return
+		}
+		if code != "AccessDenied" {
+			failureLog(function, args, startTime, "", fmt.Sprintf("DeleteObjects returned unexpected error code for %s", upload.name), fmt.Errorf("want AccessDenied, got %s", code)).Fatal()
+			return
+		}
+	}
+
+	// Only the retained versions are left
+	listOutput, err := s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(listOutput.Versions) != 2 || len(listOutput.DeleteMarkers) != 0 {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned unexpected result", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test the version id returned when a delete marker is created
+func testDeleteMarkerVersionId() {
+	startTime := time.Now()
+	function := "testDeleteMarkerVersionId"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String("Enabled"),
+		},
+	}
+
+	_, err = s3Client.PutBucketVersioning(putVersioningInput)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "Put versioning failed", err).Fatal()
+		return
+	}
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("my object content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	putOutput, err := s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// Delete without version ID creates a delete marker
+	delOutput, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Delete expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if !aws.BoolValue(delOutput.DeleteMarker) {
+		failureLog(function, args, startTime, "", "Delete did not report a delete marker", nil).Fatal()
+		return
+	}
+	markerVersionId := aws.StringValue(delOutput.VersionId)
+	if markerVersionId == "" || markerVersionId == aws.StringValue(putOutput.VersionId) {
+		failureLog(function, args, startTime, "", "Delete returned unexpected delete marker version id", fmt.Errorf("got %q", markerVersionId)).Fatal()
+		return
+	}
+
+	listOutput, err := s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(listOutput.DeleteMarkers) != 1 || aws.StringValue(listOutput.DeleteMarkers[0].VersionId) != markerVersionId {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned unexpected delete markers", nil).Fatal()
+		return
+	}
+
+	// Removing the delete marker by its version id makes the object visible again
+	delOutput, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: aws.String(markerVersionId),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Delete of the delete marker expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if !aws.BoolValue(delOutput.DeleteMarker) || aws.StringValue(delOutput.VersionId) != markerVersionId {
+		failureLog(function, args, startTime, "", "Delete of the delete marker returned unexpected response", nil).Fatal()
+		return
+	}
+
+	headOutput, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if aws.StringValue(headOutput.VersionId) != aws.StringValue(putOutput.VersionId) {
+		failureLog(function, args, startTime, "", "HEAD Object returned unexpected version id", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
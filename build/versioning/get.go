@@ -20,14 +20,18 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -169,3 +173,303 @@ func testGetObject() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// testSSEHeadersOnPlainObject tests that SSE-C headers are rejected when
+// reading an object which was not encrypted with a customer key
+func testSSEHeadersOnPlainObject() {
+	startTime := time.Now()
+	function := "testSSEHeadersOnPlainObject"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	objectContent := "my object content"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	// The SDK refuses to send customer keys over plain HTTP
+	if !strings.HasPrefix(s3Client.Endpoint, "https://") {
+		skipLog(function, args, startTime, "HTTPS is required for SSE-C").Info()
+		return
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader(objectContent)),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	getInput := &s3.GetObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(object),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       aws.String("32byteslongsecretkeymustbegiven1"),
+	}
+	_, err = s3Client.GetObject(getInput)
+	if err == nil {
+		failureLog(function, args, startTime, "", "GetObject with SSE-C headers expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		failureLog(function, args, startTime, "", "GetObject unexpected error with SSE-C headers", err).Fatal()
+		return
+	}
+	if aerr.Code() != "InvalidRequest" {
+		failureLog(function, args, startTime, "", "GetObject unexpected error with SSE-C headers", err).Fatal()
+		return
+	}
+
+	// The object is still readable without the headers
+	getInput = &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	result, err := s3Client.GetObject(getInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObject expected to succeed but failed with %v", err), err).Fatal()
+		return
+	}
+
+	body, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObject expected to return data but failed with %v", err), err).Fatal()
+		return
+	}
+	result.Body.Close()
+
+	if string(body) != objectContent {
+		failureLog(function, args, startTime, "", "GetObject unexpected body content", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// testAcceptRanges tests that HEAD and GET advertise byte range support
+func testAcceptRanges() {
+	startTime := time.Now()
+	function := "testAcceptRanges"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("my object content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	headOutput, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if aws.StringValue(headOutput.AcceptRanges) != "bytes" {
+		failureLog(function, args, startTime, "", "HEAD Object returned unexpected Accept-Ranges header", fmt.Errorf("want bytes, got %q", aws.StringValue(headOutput.AcceptRanges))).Fatal()
+		return
+	}
+
+	getOutput, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObject expected to succeed but failed with %v", err), err).Fatal()
+		return
+	}
+	getOutput.Body.Close()
+	if aws.StringValue(getOutput.AcceptRanges) != "bytes" {
+		failureLog(function, args, startTime, "", "GetObject returned unexpected Accept-Ranges header", fmt.Errorf("want bytes, got %q", aws.StringValue(getOutput.AcceptRanges))).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// testContentEncodingPassthrough tests that a gzip encoded object is
+// served back as stored, without being decompressed by the server
+func testContentEncodingPassthrough() {
+	startTime := time.Now()
+	function := "testContentEncodingPassthrough"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject.gz"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write([]byte(strings.Repeat("my object content ", 100))); err != nil {
+		failureLog(function, args, startTime, "", "gzip Write failed", err).Fatal()
+		return
+	}
+	if err = zw.Close(); err != nil {
+		failureLog(function, args, startTime, "", "gzip Close failed", err).Fatal()
+		return
+	}
+	encoded := buf.Bytes()
+
+	putInput := &s3.PutObjectInput{
+		Body:            aws.ReadSeekCloser(bytes.NewReader(encoded)),
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(object),
+		ContentEncoding: aws.String("gzip"),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	getInput := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	// Setting Accept-Encoding explicitly stops net/http from transparently
+	// decompressing the body and dropping the Content-Encoding header
+	result, err := s3Client.GetObjectWithContext(aws.BackgroundContext(), getInput,
+		request.WithSetRequestHeaders(map[string]string{"Accept-Encoding": "gzip"}))
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObject expected to succeed but failed with %v", err), err).Fatal()
+		return
+	}
+
+	body, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObject expected to return data but failed with %v", err), err).Fatal()
+		return
+	}
+	result.Body.Close()
+
+	if aws.StringValue(result.ContentEncoding) != "gzip" {
+		failureLog(function, args, startTime, "", "GetObject returned unexpected Content-Encoding header", fmt.Errorf("want gzip, got %q", aws.StringValue(result.ContentEncoding))).Fatal()
+		return
+	}
+	if !bytes.Equal(body, encoded) {
+		failureLog(function, args, startTime, "", "GetObject unexpected body content", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// testMalformedRange tests that a malformed Range header is either ignored,
+// returning the whole object, or rejected with 400/416
+func testMalformedRange() {
+	startTime := time.Now()
+	function := "testMalformedRange"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	objectContent := "my object content"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader(objectContent)),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// Record how the server handled each range
+	observed := make(map[string]interface{})
+	args["observed"] = observed
+
+	for i, rangeHeader := range []string{"bytes=abc-", "bytes=-", "bytes=10-5"} {
+		getInput := &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+			Range:  aws.String(rangeHeader),
+		}
+		result, err := s3Client.GetObject(getInput)
+		if err != nil {
+			reqErr, ok := err.(awserr.RequestFailure)
+			if !ok {
+				failureLog(function, args, startTime, "", fmt.Sprintf("GetObject(%d) unexpected error with malformed range", i+1), err).Fatal()
+				return
+			}
+			observed[rangeHeader] = reqErr.StatusCode()
+			if reqErr.StatusCode() != http.StatusBadRequest && reqErr.StatusCode() != http.StatusRequestedRangeNotSatisfiable {
+				failureLog(function, args, startTime, "", fmt.Sprintf("GetObject(%d) unexpected error with malformed range", i+1), err).Fatal()
+				return
+			}
+			continue
+		}
+		observed[rangeHeader] = http.StatusOK
+
+		body, err := ioutil.ReadAll(result.Body)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("GetObject(%d) expected to return data but failed", i+1), err).Fatal()
+			return
+		}
+		result.Body.Close()
+
+		// An ignored range falls back to the whole object
+		if string(body) != objectContent {
+			failureLog(function, args, startTime, "", fmt.Sprintf("GetObject(%d) unexpected body content", i+1), nil).Fatal()
+			return
+		}
+	}
+
+	successLogger(function, args, startTime).Info()
+}
@@ -24,37 +24,17 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	log "github.com/sirupsen/logrus"
 )
 
-// Creates a testobject
-func createTestObject(size int64, name string) {
-	fd, err := os.Create(name)
-	if err != nil {
-		log.Fatal("Failed to create testfile")
-	}
-	_, err = fd.Seek(size-1, 0)
-	if err != nil {
-		log.Fatal("Failed to seek")
-	}
-	_, err = fd.Write([]byte{0})
-	if err != nil {
-		log.Fatal("Write failed")
-	}
-	err = fd.Close()
-	if err != nil {
-		log.Fatal("Failed to close file")
-	}
-}
-
 // Test locking for different versions
 func testLockingLegalhold() {
 	startTime := time.Now()
@@ -333,16 +313,7 @@ func testLockingLegalholdMultipart() {
 		return
 	}
 
-	fileSize := 30 * 1024 * 1024
-	createTestObject(int64(fileSize), object)
-
-	f, err := os.Open(object)
-	if err != nil {
-		failureLog(function, args, startTime, "", "Open testobject failed", err).Fatal()
-		return
-	}
-	defer f.Close()
-	defer os.Remove(object)
+	data := make([]byte, 30*1024*1024)
 
 	defer cleanupBucket(bucket, function, args, startTime)
 
@@ -362,67 +333,17 @@ func testLockingLegalholdMultipart() {
 
 	// Upload versions and save their version IDs
 	for i := range uploads {
-		multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		versionId, err := putMultipartObject(&s3.CreateMultipartUploadInput{
 			Bucket:                    aws.String(bucket),
 			Key:                       aws.String(object),
 			ObjectLockLegalHoldStatus: aws.String(uploads[i].legalhold),
-		})
-		if err != nil {
-			failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
-			return
-		}
-
-		filePart := make([]byte, partSize)
-		partCount := fileSize / partSize
-		parts := make([]*string, partCount)
-		for j := 0; j < partCount; j++ {
-			_, err := f.ReadAt(filePart, int64(partSize*j))
-			if err != nil {
-				failureLog(function, args, startTime, "", "ReadAt failed", err).Fatal()
-				return
-			}
-			r := bytes.NewReader(filePart)
-
-			result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
-				Bucket:     aws.String(bucket),
-				Key:        aws.String(object),
-				UploadId:   multipartUpload.UploadId,
-				PartNumber: aws.Int64(int64(j + 1)),
-				Body:       aws.ReadSeekCloser(r),
-			})
-			if errUpload != nil {
-				_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
-					Bucket:   aws.String(bucket),
-					Key:      aws.String(object),
-					UploadId: multipartUpload.UploadId,
-				})
-				failureLog(function, args, startTime, "", "UploadPart API failed for", errUpload).Fatal()
-				return
-			}
-			parts[j] = result.ETag
-		}
-
-		completedParts := make([]*s3.CompletedPart, len(parts))
-		for i, part := range parts {
-			completedParts[i] = &s3.CompletedPart{
-				ETag:       part,
-				PartNumber: aws.Int64(int64(i + 1)),
-			}
-		}
-
-		output, err := s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(object),
-			MultipartUpload: &s3.CompletedMultipartUpload{
-				Parts: completedParts},
-			UploadId: multipartUpload.UploadId,
-		})
+		}, data, partSize)
 		if err != nil {
-			failureLog(function, args, startTime, "", "CompleteMultipartUpload is expected to succeed but failed", errors.New("expected nil")).Fatal()
+			failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
 			return
 		}
 
-		uploads[i].versionId = *output.VersionId
+		uploads[i].versionId = versionId
 	}
 
 	// In all cases, we can remove an object by creating a delete marker
@@ -630,16 +551,10 @@ func testLockingLegalholdMultipart() {
 		return
 	}
 
-	filePart := make([]byte, partSize)
-	partCount := fileSize / partSize
+	partCount := len(data) / partSize
 	parts := make([]*string, partCount)
 	for j := 0; j < partCount-1; j++ {
-		_, err := f.ReadAt(filePart, int64(partSize*j))
-		if err != nil {
-			failureLog(function, args, startTime, "", "ReadAt failed", err).Fatal()
-			return
-		}
-		r := bytes.NewReader(filePart)
+		r := bytes.NewReader(data[partSize*j : partSize*(j+1)])
 
 		result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
 			Bucket:     aws.String(bucket),
@@ -683,3 +598,347 @@ func testLockingLegalholdMultipart() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test legal hold with an invalid status is cleanly rejected
+func testLegalHoldMalformedStatus() {
+	startTime := time.Now()
+	function := "testLegalHoldMalformedStatus"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	output, err := s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	polhInput := &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String("MAYBE")},
+		VersionId: output.VersionId,
+	}
+	_, err = s3Client.PutObjectLegalHold(polhInput)
+	if err == nil {
+		failureLog(function, args, startTime, "", "PutObjectLegalHold expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		failureLog(function, args, startTime, "", "PutObjectLegalHold unexpected error with malformed status", err).Fatal()
+		return
+	}
+	if aerr.Code() != "MalformedXML" && aerr.Code() != "InvalidArgument" {
+		failureLog(function, args, startTime, "", "PutObjectLegalHold unexpected error with malformed status", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test legal hold cannot be bypassed like governance retention
+func testLegalHoldNoBypass() {
+	startTime := time.Now()
+	function := "testLegalHoldNoBypass"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	type uploadedObject struct {
+		legalhold string
+		versionId string
+	}
+
+	uploads := []uploadedObject{
+		{legalhold: "ON"},
+		{legalhold: "OFF"},
+	}
+
+	// Upload versions and save their version IDs
+	for i := range uploads {
+		putInput := &s3.PutObjectInput{
+			Body:                      aws.ReadSeekCloser(strings.NewReader("content")),
+			Bucket:                    aws.String(bucket),
+			Key:                       aws.String(object),
+			ObjectLockLegalHoldStatus: aws.String(uploads[i].legalhold),
+		}
+		output, err := s3Client.PutObject(putInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		uploads[i].versionId = *output.VersionId
+	}
+
+	// Governance bypass only applies to retention, not to legal hold
+	for i := range uploads {
+		deleteInput := &s3.DeleteObjectInput{
+			Bucket:                    aws.String(bucket),
+			Key:                       aws.String(object),
+			VersionId:                 aws.String(uploads[i].versionId),
+			BypassGovernanceRetention: aws.Bool(true),
+		}
+		_, err = s3Client.DeleteObject(deleteInput)
+		if err == nil && uploads[i].legalhold == "ON" {
+			failureLog(function, args, startTime, "", "DELETE with governance bypass expected to fail but succeed instead", nil).Fatal()
+			return
+		}
+		if err != nil && uploads[i].legalhold == "OFF" {
+			failureLog(function, args, startTime, "", fmt.Sprintf("DELETE expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+	}
+
+	// The held version must still be there
+	headInput := &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: aws.String(uploads[0].versionId),
+	}
+	_, err = s3Client.HeadObject(headInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// Release the legal hold so the bucket can be cleaned up
+	polhInput := &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String("OFF")},
+		VersionId: aws.String(uploads[0].versionId),
+	}
+	_, err = s3Client.PutObjectLegalHold(polhInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Turning off legalhold failed with %v", err), err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test reading the object lock configuration of a bucket without object lock
+func testLockConfigOnNonLockBucket() {
+	startTime := time.Now()
+	function := "testLockConfigOnNonLockBucket"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(false),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	// Bucket is missing ObjectLockConfiguration
+	_, err = s3Client.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "GetObjectLockConfiguration expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		failureLog(function, args, startTime, "", "GetObjectLockConfiguration unexpected error", err).Fatal()
+		return
+	}
+	if aerr.Code() == "NotImplemented" {
+		ignoreLog(function, args, startTime, "GetObjectLockConfiguration is not implemented").Info()
+		return
+	}
+	if aerr.Code() != "ObjectLockConfigurationNotFoundError" {
+		failureLog(function, args, startTime, "", "GetObjectLockConfiguration unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test reading legal hold status while it is being toggled
+func testLegalHoldReadWriteConcurrency() {
+	startTime := time.Now()
+	function := "testLegalHoldReadWriteConcurrency"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	writes := 20
+	readers := 4
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+		"writes":     writes,
+		"readers":    readers,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:                      aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(object),
+		ObjectLockLegalHoldStatus: aws.String("ON"),
+	}
+	output, err := s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	versionId := *output.VersionId
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		readErrs  []error
+		writeErr  error
+		lastWrite = "ON"
+	)
+	done := make(chan struct{})
+
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				result, err := s3Client.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+					Bucket:    aws.String(bucket),
+					Key:       aws.String(object),
+					VersionId: aws.String(versionId),
+				})
+				if err == nil && result.LegalHold != nil {
+					if status := aws.StringValue(result.LegalHold.Status); status == "ON" || status == "OFF" {
+						continue
+					}
+					err = fmt.Errorf("unexpected legal hold status %v", result.LegalHold)
+				}
+				if err == nil {
+					err = errors.New("missing legal hold in response")
+				}
+				mu.Lock()
+				readErrs = append(readErrs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < writes; i++ {
+		status := "OFF"
+		if lastWrite == "OFF" {
+			status = "ON"
+		}
+		_, writeErr = s3Client.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(object),
+			LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+			VersionId: aws.String(versionId),
+		})
+		if writeErr != nil {
+			break
+		}
+		lastWrite = status
+	}
+	close(done)
+	wg.Wait()
+
+	if writeErr != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutObjectLegalHold expected to succeed but got %v", writeErr), writeErr).Fatal()
+		return
+	}
+	if len(readErrs) > 0 {
+		args["readErrors"] = len(readErrs)
+		failureLog(function, args, startTime, "", "GetObjectLegalHold returned an invalid response while toggling", readErrs[0]).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObjectLegalHold expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if result.LegalHold == nil || aws.StringValue(result.LegalHold.Status) != lastWrite {
+		failureLog(function, args, startTime, "", "GetObjectLegalHold does not match the last write", nil).Fatal()
+		return
+	}
+
+	// Release the legal hold so the bucket can be cleaned up
+	if lastWrite == "ON" {
+		_, err = s3Client.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(object),
+			LegalHold: &s3.ObjectLockLegalHold{Status: aws.String("OFF")},
+			VersionId: aws.String(versionId),
+		})
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("Turning off legalhold failed with %v", err), err).Fatal()
+			return
+		}
+	}
+
+	successLogger(function, args, startTime).Info()
+}
@@ -730,3 +730,125 @@ func testListObjectsVersionsWithEmptyDirObject() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test ListObjectsV2 hides keys whose latest version is a delete marker
+func testListObjectsExcludesDeleteMarkers() {
+	startTime := time.Now()
+	function := "testListObjectsExcludesDeleteMarkers"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String("Enabled"),
+		},
+	}
+
+	_, err = s3Client.PutBucketVersioning(putVersioningInput)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "Put versioning failed", err).Fatal()
+		return
+	}
+
+	// "deleted" ends with a delete marker, "live" has a delete marker
+	// followed by a new version
+	steps := []struct {
+		objectName string
+		delete     bool
+	}{
+		{objectName: "deleted"},
+		{objectName: "deleted", delete: true},
+		{objectName: "live"},
+		{objectName: "live", delete: true},
+		{objectName: "live"},
+	}
+
+	for _, step := range steps {
+		if step.delete {
+			_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(step.objectName),
+			})
+			if err != nil {
+				failureLog(function, args, startTime, "", fmt.Sprintf("DELETE expected to succeed but got %v", err), err).Fatal()
+				return
+			}
+			continue
+		}
+		putInput := &s3.PutObjectInput{
+			Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+			Bucket: aws.String(bucket),
+			Key:    aws.String(step.objectName),
+		}
+		_, err = s3Client.PutObject(putInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+	}
+
+	listOutput, err := s3Client.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectsV2 expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	var gotObjects []string
+	for _, obj := range listOutput.Contents {
+		gotObjects = append(gotObjects, *obj.Key)
+	}
+	if !reflect.DeepEqual(gotObjects, []string{"live"}) {
+		failureLog(function, args, startTime, "", "ListObjectsV2 returned unexpected listing result", fmt.Errorf("want %v, got %v", []string{"live"}, gotObjects)).Fatal()
+		return
+	}
+
+	versionsOutput, err := s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	type markerResult struct {
+		name     string
+		isLatest bool
+	}
+	var gotMarkers []markerResult
+	for _, marker := range versionsOutput.DeleteMarkers {
+		gotMarkers = append(gotMarkers, markerResult{name: *marker.Key, isLatest: *marker.IsLatest})
+	}
+	expectedMarkers := []markerResult{
+		{name: "deleted", isLatest: true},
+		{name: "live", isLatest: false},
+	}
+	if !reflect.DeepEqual(gotMarkers, expectedMarkers) {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned unexpected delete markers", fmt.Errorf("want %+v, got %+v", expectedMarkers, gotMarkers)).Fatal()
+		return
+	}
+
+	if len(versionsOutput.Versions) != 3 {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned unexpected result", errors.New("unexpected number of versions")).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
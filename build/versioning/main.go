@@ -16,19 +16,33 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
 	log "github.com/sirupsen/logrus"
 )
 
 // S3 client for testing
 var s3Client *s3.S3
 
+// Credentials of a second identity, nil unless SECOND_ACCESS_KEY and
+// SECOND_SECRET_KEY are set
+var secondCredentials *credentials.Credentials
+
 func cleanupBucket(bucket string, function string, args map[string]interface{}, startTime time.Time) {
 	start := time.Now()
 
@@ -36,36 +50,82 @@ func cleanupBucket(bucket string, function string, args map[string]interface{},
 		Bucket: aws.String(bucket),
 	}
 
+	// Fall back to removing versions one by one when the server
+	// does not implement the multi delete API
+	bulkDelete := true
+
+	deleteOne := func(object *s3.ObjectIdentifier) error {
+		input := &s3.DeleteObjectInput{
+			Bucket:                    &bucket,
+			Key:                       object.Key,
+			VersionId:                 object.VersionId,
+			BypassGovernanceRetention: aws.Bool(true),
+		}
+		_, err := s3Client.DeleteObject(input)
+		return err
+	}
+
+	deleteBatch := func(objects []*s3.ObjectIdentifier) error {
+		if bulkDelete {
+			// Per-object failures are reported in the Errors slice and
+			// are retried on the next pass
+			_, err := s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket:                    &bucket,
+				Delete:                    &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+				BypassGovernanceRetention: aws.Bool(true),
+			})
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NotImplemented" {
+				return err
+			}
+			bulkDelete = false
+		}
+		for _, object := range objects {
+			if err := deleteOne(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	for time.Since(start) < 30*time.Minute {
 		err := s3Client.ListObjectVersionsPages(input,
 			func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+				var objects []*s3.ObjectIdentifier
 				for _, v := range page.Versions {
-					input := &s3.DeleteObjectInput{
-						Bucket:                    &bucket,
-						Key:                       v.Key,
-						VersionId:                 v.VersionId,
-						BypassGovernanceRetention: aws.Bool(true),
-					}
-					_, err := s3Client.DeleteObject(input)
-					if err != nil {
-						return true
-					}
+					objects = append(objects, &s3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
 				}
 				for _, v := range page.DeleteMarkers {
-					input := &s3.DeleteObjectInput{
-						Bucket:                    &bucket,
-						Key:                       v.Key,
-						VersionId:                 v.VersionId,
-						BypassGovernanceRetention: aws.Bool(true),
+					objects = append(objects, &s3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+				}
+				// DeleteObjects accepts at most 1000 keys per request
+				for len(objects) > 0 {
+					n := len(objects)
+					if n > 1000 {
+						n = 1000
 					}
-					_, err := s3Client.DeleteObject(input)
-					if err != nil {
+					if err := deleteBatch(objects[:n]); err != nil {
 						return true
 					}
+					objects = objects[n:]
 				}
 				return true
 			})
 
+		// Incomplete multipart uploads also keep the bucket from being removed,
+		// a failed listing is retried along with DeleteBucket
+		_ = s3Client.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+			Bucket: aws.String(bucket),
+		}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			for _, upload := range page.Uploads {
+				_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+					Bucket:   aws.String(bucket),
+					Key:      upload.Key,
+					UploadId: upload.UploadId,
+				})
+			}
+			return true
+		})
+
 		_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{
 			Bucket: aws.String(bucket),
 		})
@@ -80,7 +140,124 @@ func cleanupBucket(bucket string, function string, args map[string]interface{},
 	return
 }
 
+// newHTTPClient returns an HTTP client trusting the PEM encoded certificates
+// in caCertFile in addition to the system ones
+func newHTTPClient(caCertFile string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caCertFile != "" {
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// testEntry is a test case run by main in registration order
+type testEntry struct {
+	name string
+	fn   func()
+}
+
+var tests = []testEntry{
+	{"testMakeBucket", testMakeBucket},
+	{"testCreateBucketIdempotency", testCreateBucketIdempotency},
+	{"testBucketChurn", testBucketChurn},
+	{"testLifecycleOnLockBucket", testLifecycleOnLockBucket},
+	{"testInvalidTransitionStorageClass", testInvalidTransitionStorageClass},
+	{"testTransitionMissingStorageClass", testTransitionMissingStorageClass},
+	{"testRuleIdEncoding", testRuleIdEncoding},
+	{"testConflictingFilterFields", testConflictingFilterFields},
+	{"testFilterNormalization", testFilterNormalization},
+	{"testLifecycleConfigChurn", testLifecycleConfigChurn},
+	{"testExpirationHeader", testExpirationHeader},
+	{"testGetLifecycleConfiguration", testGetLifecycleConfiguration},
+	{"testPutObject", testPutObject},
+	{"testPutObjectWithTaggingAndMetadata", testPutObjectWithTaggingAndMetadata},
+	{"testKnownETag", testKnownETag},
+	{"testManyMetadataEntries", testManyMetadataEntries},
+	{"testMultipartMismatchedPartSizes", testMultipartMismatchedPartSizes},
+	{"testFailedCompleteNoVersion", testFailedCompleteNoVersion},
+	{"testListMultipartUploadsPagination", testListMultipartUploadsPagination},
+	{"testGetObjectAttributesParts", testGetObjectAttributesParts},
+	{"testMultipartPartsCountHeader", testMultipartPartsCountHeader},
+	{"testDeleteBucketWithActiveUpload", testDeleteBucketWithActiveUpload},
+	{"testGetObject", testGetObject},
+	{"testSSEHeadersOnPlainObject", testSSEHeadersOnPlainObject},
+	{"testAcceptRanges", testAcceptRanges},
+	{"testContentEncodingPassthrough", testContentEncodingPassthrough},
+	{"testMalformedRange", testMalformedRange},
+	{"testStatObject", testStatObject},
+	{"testDeleteObject", testDeleteObject},
+	{"testDeleteMarkerVersionId", testDeleteMarkerVersionId},
+	{"testDeleteObjects", testDeleteObjects},
+	{"testDeleteObjectsPartialFailure", testDeleteObjectsPartialFailure},
+	{"testListObjectVersionsSimple", testListObjectVersionsSimple},
+	{"testListObjectVersionsWithPrefixAndDelimiter", testListObjectVersionsWithPrefixAndDelimiter},
+	{"testListObjectVersionsKeysContinuation", testListObjectVersionsKeysContinuation},
+	{"testListObjectVersionsVersionIDContinuation", testListObjectVersionsVersionIDContinuation},
+	{"testListObjectsVersionsWithEmptyDirObject", testListObjectsVersionsWithEmptyDirObject},
+	{"testListObjectsExcludesDeleteMarkers", testListObjectsExcludesDeleteMarkers},
+	{"testTagging", testTagging},
+	{"testTagLimit", testTagLimit},
+	{"testLockingLegalhold", testLockingLegalhold},
+	{"testLockingLegalholdMultipart", testLockingLegalholdMultipart},
+	{"testLegalHoldMalformedStatus", testLegalHoldMalformedStatus},
+	{"testLegalHoldNoBypass", testLegalHoldNoBypass},
+	{"testLockConfigOnNonLockBucket", testLockConfigOnNonLockBucket},
+	{"testLegalHoldReadWriteConcurrency", testLegalHoldReadWriteConcurrency},
+	{"testPutGetRetentionCompliance", testPutGetRetentionCompliance},
+	{"testPutGetDeleteRetentionGovernance", testPutGetDeleteRetentionGovernance},
+	{"testPutGetDeleteRetentionGovernanceMultipart", testPutGetDeleteRetentionGovernanceMultipart},
+	{"testLockingRetentionGovernance", testLockingRetentionGovernance},
+	{"testLockingRetentionGovernanceLatestVersionRetention", testLockingRetentionGovernanceLatestVersionRetention},
+	{"testLockingRetentionGovernanceMultipart", testLockingRetentionGovernanceMultipart},
+	{"testLockingRetentionCompliance", testLockingRetentionCompliance},
+	{"testLockingRetentionComplianceLatestVersionRetention", testLockingRetentionComplianceLatestVersionRetention},
+	{"testDefaultRetentionMultipart", testDefaultRetentionMultipart},
+	{"testRetentionPastDate", testRetentionPastDate},
+	{"testBypassRequiresVersionId", testBypassRequiresVersionId},
+	{"testObjectLockRetention", testObjectLockRetention},
+}
+
+// selectTests returns the registered tests whose name fully matches one of
+// the comma separated regular expressions in filter, or all of them when
+// filter is empty
+func selectTests(filter string) ([]testEntry, error) {
+	if filter == "" {
+		return tests, nil
+	}
+	patterns := strings.Split(filter, ",")
+	for i := range patterns {
+		patterns[i] = strings.TrimSpace(patterns[i])
+	}
+	re, err := regexp.Compile("^(?:" + strings.Join(patterns, "|") + ")$")
+	if err != nil {
+		return nil, err
+	}
+	var selected []testEntry
+	for _, test := range tests {
+		if re.MatchString(test.name) {
+			selected = append(selected, test)
+		}
+	}
+	return selected, nil
+}
+
 func main() {
+	startTime := time.Now()
 	endpoint := os.Getenv("SERVER_ENDPOINT")
 	accessKey := os.Getenv("ACCESS_KEY")
 	secretKey := os.Getenv("SECRET_KEY")
@@ -90,20 +267,80 @@ func main() {
 		sdkEndpoint = "https://" + endpoint
 	}
 
+	httpClient, err := newHTTPClient(os.Getenv("CA_CERT_FILE"), os.Getenv("INSECURE_SKIP_VERIFY") == "1")
+	if err != nil {
+		log.Fatalf("Unable to configure TLS: %v", err)
+	}
+
+	runTests := os.Getenv("RUN_TESTS")
+	selected, err := selectTests(runTests)
+	if err != nil {
+		log.Fatalf("Invalid RUN_TESTS filter: %v", err)
+	}
+	if len(selected) == 0 {
+		log.Fatalf("RUN_TESTS filter %q does not match any test", runTests)
+	}
+
 	creds := credentials.NewStaticCredentials(accessKey, secretKey, "")
+	if secondAccessKey, secondSecretKey := os.Getenv("SECOND_ACCESS_KEY"), os.Getenv("SECOND_SECRET_KEY"); secondAccessKey != "" && secondSecretKey != "" {
+		secondCredentials = credentials.NewStaticCredentials(secondAccessKey, secondSecretKey, "")
+	}
 	newSession := session.New()
+
+	// Use temporary credentials obtained with STS AssumeRole when configured
+	stsEndpoint := os.Getenv("STS_ENDPOINT")
+	roleARN := os.Getenv("STS_ROLE_ARN")
+	if stsEndpoint != "" && roleARN != "" {
+		sdkSTSEndpoint := "http://" + stsEndpoint
+		if secure == "1" {
+			sdkSTSEndpoint = "https://" + stsEndpoint
+		}
+		stsClient := sts.New(newSession, &aws.Config{
+			Credentials: creds,
+			Endpoint:    aws.String(sdkSTSEndpoint),
+			Region:      aws.String("us-east-1"),
+			HTTPClient:  httpClient,
+		})
+		creds = stscreds.NewCredentialsWithClient(stsClient, roleARN)
+	}
+
 	s3Config := &aws.Config{
 		Credentials:      creds,
 		Endpoint:         aws.String(sdkEndpoint),
 		Region:           aws.String("us-east-1"),
 		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       httpClient,
 	}
 
 	// Create an S3 service object in the default region.
 	s3Client = s3.New(newSession, s3Config)
 
-	// Output to stdout instead of the default stderr
-	log.SetOutput(os.Stdout)
+	summary := &summarySink{}
+	addResultSink(summary)
+	log.AddHook(resultHook{})
+
+	// Output to stdout instead of the default stderr, optionally tee-ing
+	// the JSON lines into MINT_LOG_FILE or sending them only to that file
+	var logOutput io.Writer = os.Stdout
+	if logFile := os.Getenv("MINT_LOG_FILE"); logFile != "" {
+		f, err := os.Create(logFile)
+		if err != nil {
+			log.Fatalf("Unable to create log file %s: %v", logFile, err)
+		}
+		closeLogFile := func() {
+			f.Sync()
+			f.Close()
+		}
+		// failureLog(...).Fatal() exits without running deferred calls
+		log.RegisterExitHandler(closeLogFile)
+		defer closeLogFile()
+
+		logOutput = f
+		if os.Getenv("MINT_LOG_STDOUT") != "0" {
+			logOutput = io.MultiWriter(os.Stdout, f)
+		}
+	}
+	log.SetOutput(logOutput)
 	// create custom formatter
 	mintFormatter := mintJSONFormatter{}
 	// set custom formatter
@@ -111,27 +348,23 @@ func main() {
 	// log Info or above -- success cases are Info level, failures are Fatal level
 	log.SetLevel(log.InfoLevel)
 
-	testMakeBucket()
-	testPutObject()
-	testPutObjectWithTaggingAndMetadata()
-	testGetObject()
-	testStatObject()
-	testDeleteObject()
-	testDeleteObjects()
-	testListObjectVersionsSimple()
-	testListObjectVersionsWithPrefixAndDelimiter()
-	testListObjectVersionsKeysContinuation()
-	testListObjectVersionsVersionIDContinuation()
-	testListObjectsVersionsWithEmptyDirObject()
-	testTagging()
-	testLockingLegalhold()
-	testLockingLegalholdMultipart()
-	testPutGetRetentionCompliance()
-	testPutGetDeleteRetentionGovernance()
-	testPutGetDeleteRetentionGovernanceMultipart()
-	testLockingRetentionGovernance()
-	testLockingRetentionGovernanceLatestVersionRetention()
-	testLockingRetentionGovernanceMultipart()
-	testLockingRetentionCompliance()
-	testLockingRetentionComplianceLatestVersionRetention()
+	// Only enumerate the selected tests without touching the server
+	if os.Getenv("LIST_TESTS") == "1" {
+		for _, test := range selected {
+			plannedLog(test.name).Info()
+		}
+		return
+	}
+
+	// Failures exit early through Fatal, so the summary is also written
+	// from an exit handler, ahead of the one closing MINT_LOG_FILE
+	log.DeferExitHandler(func() {
+		summary.logger(startTime).Info()
+	})
+
+	for _, test := range selected {
+		test.fn()
+	}
+
+	summary.logger(startTime).Info()
 }
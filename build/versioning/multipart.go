@@ -0,0 +1,636 @@
+/*
+*
+*  Mint, (C) 2021 Minio, Inc.
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Test that only the last part of a multipart upload may be smaller than 5 MiB
+func testMultipartMismatchedPartSizes() {
+	startTime := time.Now()
+	function := "testMultipartMismatchedPartSizes"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
+	smallPartSize := 1024 * 1024
+
+	testCases := []struct {
+		partSizes     []int
+		expectedError string
+	}{
+		// A small part in the middle is rejected
+		{partSizes: []int{partSize, smallPartSize, partSize}, expectedError: "EntityTooSmall"},
+		// A small last part is allowed
+		{partSizes: []int{partSize, smallPartSize}},
+	}
+
+	for i, testCase := range testCases {
+		multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+		})
+		if err != nil {
+			failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
+			return
+		}
+
+		completedParts := make([]*s3.CompletedPart, len(testCase.partSizes))
+		for j, size := range testCase.partSizes {
+			result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String(bucket),
+				Key:        aws.String(object),
+				UploadId:   multipartUpload.UploadId,
+				PartNumber: aws.Int64(int64(j + 1)),
+				Body:       aws.ReadSeekCloser(bytes.NewReader(bytes.Repeat([]byte{'a'}, size))),
+			})
+			if errUpload != nil {
+				_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+					Bucket:   aws.String(bucket),
+					Key:      aws.String(object),
+					UploadId: multipartUpload.UploadId,
+				})
+				failureLog(function, args, startTime, "", "UploadPart API failed for", errUpload).Fatal()
+				return
+			}
+			completedParts[j] = &s3.CompletedPart{
+				ETag:       result.ETag,
+				PartNumber: aws.Int64(int64(j + 1)),
+			}
+		}
+
+		_, err = s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+			MultipartUpload: &s3.CompletedMultipartUpload{
+				Parts: completedParts},
+			UploadId: multipartUpload.UploadId,
+		})
+		if testCase.expectedError == "" {
+			if err != nil {
+				failureLog(function, args, startTime, "", fmt.Sprintf("CompleteMultipartUpload(%d) is expected to succeed but failed", i+1), err).Fatal()
+				return
+			}
+			continue
+		}
+
+		// Do not leave the rejected upload behind
+		_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(object),
+			UploadId: multipartUpload.UploadId,
+		})
+
+		if err == nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("CompleteMultipartUpload(%d) is expected to fail but succeeded", i+1), nil).Fatal()
+			return
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != testCase.expectedError {
+			failureLog(function, args, startTime, "", fmt.Sprintf("CompleteMultipartUpload(%d) unexpected error", i+1), err).Fatal()
+			return
+		}
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a failed CompleteMultipartUpload does not create a new version
+func testFailedCompleteNoVersion() {
+	startTime := time.Now()
+	function := "testFailedCompleteNoVersion"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String("Enabled"),
+		},
+	}
+
+	_, err = s3Client.PutBucketVersioning(putVersioningInput)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "Put versioning failed", err).Fatal()
+		return
+	}
+
+	putInput := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	listVersions := func() ([]string, error) {
+		listOutput, err := s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			return nil, err
+		}
+		var versions []string
+		for _, version := range listOutput.Versions {
+			versions = append(versions, *version.VersionId)
+		}
+		for _, marker := range listOutput.DeleteMarkers {
+			versions = append(versions, *marker.VersionId)
+		}
+		return versions, nil
+	}
+
+	versionsBefore, err := listVersions()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
+		return
+	}
+	defer s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(object),
+		UploadId: multipartUpload.UploadId,
+	})
+
+	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
+
+	completedParts := make([]*s3.CompletedPart, 2)
+	for j := range completedParts {
+		result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(object),
+			UploadId:   multipartUpload.UploadId,
+			PartNumber: aws.Int64(int64(j + 1)),
+			Body:       aws.ReadSeekCloser(bytes.NewReader(bytes.Repeat([]byte{'a'}, partSize))),
+		})
+		if errUpload != nil {
+			failureLog(function, args, startTime, "", "UploadPart API failed for", errUpload).Fatal()
+			return
+		}
+		completedParts[j] = &s3.CompletedPart{
+			ETag:       result.ETag,
+			PartNumber: aws.Int64(int64(j + 1)),
+		}
+	}
+
+	// Deliberately send a wrong ETag for the first part
+	completedParts[0].ETag = aws.String("\"00000000000000000000000000000000\"")
+
+	_, err = s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts},
+		UploadId: multipartUpload.UploadId,
+	})
+	// The mismatching ETag is reported as an invalid part
+	if err == nil {
+		failureLog(function, args, startTime, "", "CompleteMultipartUpload is expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != "InvalidPart" {
+		failureLog(function, args, startTime, "", "CompleteMultipartUpload returned unexpected error", err).Fatal()
+		return
+	}
+
+	versionsAfter, err := listVersions()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if !reflect.DeepEqual(versionsBefore, versionsAfter) {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned unexpected result after failed complete", fmt.Errorf("want %v, got %v", versionsBefore, versionsAfter)).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test paginating and filtering the list of ongoing multipart uploads
+func testListMultipartUploadsPagination() {
+	startTime := time.Now()
+	function := "testListMultipartUploadsPagination"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	prefix := "prefix/"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"prefix":     prefix,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	type upload struct {
+		key      string
+		uploadId string
+	}
+
+	// Distinct keys under the prefix so that pagination has to advance
+	// the key marker, some with several uploads so that it also has to
+	// use the upload id marker, and a key outside of the prefix
+	keys := []string{
+		prefix + "a", prefix + "a",
+		prefix + "b",
+		prefix + "c", prefix + "c", prefix + "c",
+		"other/a", "other/a",
+	}
+	var uploads []upload
+	for i, key := range keys {
+		multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("CreateMultipartupload(%d) API failed", i+1), err).Fatal()
+			return
+		}
+		uploads = append(uploads, upload{key: key, uploadId: *multipartUpload.UploadId})
+	}
+	defer func() {
+		for _, u := range uploads {
+			_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      aws.String(u.key),
+				UploadId: aws.String(u.uploadId),
+			})
+		}
+	}()
+
+	seen := make(map[upload]int)
+	input := &s3.ListMultipartUploadsInput{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(prefix),
+		MaxUploads: aws.Int64(2),
+	}
+	for pages := 0; ; pages++ {
+		if pages > len(uploads) {
+			failureLog(function, args, startTime, "", "ListMultipartUploads did not finish paginating", nil).Fatal()
+			return
+		}
+		result, err := s3Client.ListMultipartUploads(input)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("ListMultipartUploads expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		if len(result.Uploads) > 2 {
+			failureLog(function, args, startTime, "", "ListMultipartUploads returned more uploads than MaxUploads", nil).Fatal()
+			return
+		}
+		for _, u := range result.Uploads {
+			seen[upload{key: *u.Key, uploadId: *u.UploadId}]++
+		}
+		if !aws.BoolValue(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.UploadIdMarker = result.NextUploadIdMarker
+	}
+
+	expected := 0
+	for _, u := range uploads {
+		count := seen[u]
+		if strings.HasPrefix(u.key, prefix) {
+			expected++
+			if count != 1 {
+				failureLog(function, args, startTime, "", fmt.Sprintf("ListMultipartUploads returned upload %s of %s %d times", u.uploadId, u.key, count), nil).Fatal()
+				return
+			}
+		} else if count != 0 {
+			failureLog(function, args, startTime, "", fmt.Sprintf("ListMultipartUploads returned upload %s of %s outside of prefix", u.uploadId, u.key), nil).Fatal()
+			return
+		}
+	}
+	if len(seen) != expected {
+		failureLog(function, args, startTime, "", "ListMultipartUploads returned unexpected uploads", fmt.Errorf("want %d, got %d", expected, len(seen))).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test GetObjectAttributes reporting the parts of a multipart object
+func testGetObjectAttributesParts() {
+	startTime := time.Now()
+	function := "testGetObjectAttributesParts"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
+	data := bytes.Repeat([]byte{'a'}, 2*partSize+1024*1024)
+
+	// Every part but the shorter last one is partSize bytes
+	var partSizes []int
+	for offset := 0; offset < len(data); offset += partSize {
+		size := len(data) - offset
+		if size > partSize {
+			size = partSize
+		}
+		partSizes = append(partSizes, size)
+	}
+	_, err = putMultipartObject(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}, data, partSize)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	result, err := s3Client.GetObjectAttributes(&s3.GetObjectAttributesInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(object),
+		ObjectAttributes: aws.StringSlice([]string{s3.ObjectAttributesObjectParts, s3.ObjectAttributesObjectSize}),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "GetObjectAttributes is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObjectAttributes expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	if result.ObjectParts == nil || aws.Int64Value(result.ObjectParts.TotalPartsCount) != int64(len(partSizes)) {
+		failureLog(function, args, startTime, "", "GetObjectAttributes returned unexpected parts count", nil).Fatal()
+		return
+	}
+	if len(result.ObjectParts.Parts) != len(partSizes) {
+		failureLog(function, args, startTime, "", "GetObjectAttributes returned unexpected parts", fmt.Errorf("want %d parts, got %d", len(partSizes), len(result.ObjectParts.Parts))).Fatal()
+		return
+	}
+
+	var totalSize int64
+	for j, part := range result.ObjectParts.Parts {
+		if aws.Int64Value(part.PartNumber) != int64(j+1) || aws.Int64Value(part.Size) != int64(partSizes[j]) {
+			failureLog(function, args, startTime, "", fmt.Sprintf("GetObjectAttributes returned unexpected part %d", j+1), fmt.Errorf("got number %d, size %d", aws.Int64Value(part.PartNumber), aws.Int64Value(part.Size))).Fatal()
+			return
+		}
+		totalSize += aws.Int64Value(part.Size)
+	}
+	if aws.Int64Value(result.ObjectSize) != totalSize {
+		failureLog(function, args, startTime, "", "GetObjectAttributes returned an object size different from the sum of its parts", fmt.Errorf("want %d, got %d", totalSize, aws.Int64Value(result.ObjectSize))).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that x-amz-mp-parts-count is reported for multipart objects only
+func testMultipartPartsCountHeader() {
+	startTime := time.Now()
+	function := "testMultipartPartsCountHeader"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	singleObject := "testSingleObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
+	data := bytes.Repeat([]byte{'a'}, 2*partSize+1024*1024)
+	partCount := (len(data) + partSize - 1) / partSize
+	_, err = putMultipartObject(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}, data, partSize)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// The parts count is only reported when a part number is requested
+	headOutput, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(object),
+		PartNumber: aws.Int64(1),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if aws.Int64Value(headOutput.PartsCount) != int64(partCount) {
+		failureLog(function, args, startTime, "", "HEAD returned unexpected x-amz-mp-parts-count", fmt.Errorf("want %d, got %d", partCount, aws.Int64Value(headOutput.PartsCount))).Fatal()
+		return
+	}
+
+	getOutput, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(object),
+		PartNumber: aws.Int64(1),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GET expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	getOutput.Body.Close()
+	if aws.Int64Value(getOutput.PartsCount) != int64(partCount) {
+		failureLog(function, args, startTime, "", "GET returned unexpected x-amz-mp-parts-count", fmt.Errorf("want %d, got %d", partCount, aws.Int64Value(getOutput.PartsCount))).Fatal()
+		return
+	}
+
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(singleObject),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	headOutput, err = s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(singleObject),
+		PartNumber: aws.Int64(1),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if headOutput.PartsCount != nil {
+		failureLog(function, args, startTime, "", "HEAD returned x-amz-mp-parts-count for a single PUT object", fmt.Errorf("got %d", aws.Int64Value(headOutput.PartsCount))).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that a pending multipart upload keeps a bucket from being deleted
+func testDeleteBucketWithActiveUpload() {
+	startTime := time.Now()
+	function := "testDeleteBucketWithActiveUpload"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	deleted := false
+	defer func() {
+		if !deleted {
+			cleanupBucket(bucket, function, args, startTime)
+		}
+	}()
+
+	multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
+		return
+	}
+
+	_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "DeleteBucket with an active multipart upload expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != "BucketNotEmpty" {
+		failureLog(function, args, startTime, "", "DeleteBucket with an active multipart upload returned unexpected error", err).Fatal()
+		return
+	}
+
+	_, err = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(object),
+		UploadId: multipartUpload.UploadId,
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("AbortMultipartUpload expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("DeleteBucket expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	deleted = true
+
+	_, err = s3Client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "HeadBucket expected to fail on a deleted bucket but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok = err.(awserr.Error)
+	if !ok || aerr.Code() != "NotFound" {
+		failureLog(function, args, startTime, "", "HeadBucket on a deleted bucket returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
@@ -20,6 +20,8 @@
 package main
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -29,6 +31,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -295,3 +298,152 @@ func testPutObjectWithTaggingAndMetadata() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Put objects with known content and check their ETag
+func testKnownETag() {
+	startTime := time.Now()
+	function := "testKnownETag"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	args := map[string]interface{}{
+		"bucketName": bucket,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	testCases := []struct {
+		objectName string
+		content    string
+	}{
+		{"testObject", "my content 1"},
+		{"emptyObject", ""},
+	}
+
+	for i, testCase := range testCases {
+		sum := md5.Sum([]byte(testCase.content))
+		expectedETag := "\"" + hex.EncodeToString(sum[:]) + "\""
+
+		putInput := &s3.PutObjectInput{
+			Body:   aws.ReadSeekCloser(strings.NewReader(testCase.content)),
+			Bucket: aws.String(bucket),
+			Key:    aws.String(testCase.objectName),
+		}
+		putOutput, err := s3Client.PutObject(putInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		if aws.StringValue(putOutput.ETag) != expectedETag {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT(%d) returned unexpected ETag", i+1), fmt.Errorf("want %s, got %s", expectedETag, aws.StringValue(putOutput.ETag))).Fatal()
+			return
+		}
+
+		headInput := &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(testCase.objectName),
+		}
+		headOutput, err := s3Client.HeadObject(headInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		if aws.StringValue(headOutput.ETag) != expectedETag {
+			failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object(%d) returned unexpected ETag", i+1), fmt.Errorf("want %s, got %s", expectedETag, aws.StringValue(headOutput.ETag))).Fatal()
+			return
+		}
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Put objects with many user metadata entries, within and above the 2KB limit
+func testManyMetadataEntries() {
+	startTime := time.Now()
+	function := "testManyMetadataEntries"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	// Each entry accounts for 17 bytes of header name, X-Amz-Meta-Key-NN,
+	// and 90 bytes of value against the 2 KB user metadata limit
+	makeMetadata := func(entries int) map[string]*string {
+		metadata := make(map[string]*string, entries)
+		for i := 0; i < entries; i++ {
+			metadata[fmt.Sprintf("Key-%02d", i)] = aws.String(strings.Repeat("v", 90))
+		}
+		return metadata
+	}
+
+	// 18 entries, 1926 bytes in total
+	metadata := makeMetadata(18)
+	putInput := &s3.PutObjectInput{
+		Body:     aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(object),
+		Metadata: metadata,
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	result, err := s3Client.HeadObject(headInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("HEAD Object expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	for expectedKey, expectedVal := range metadata {
+		gotValue, ok := result.Metadata[expectedKey]
+		if !ok {
+			failureLog(function, args, startTime, "", "HEAD Object returned unexpected metadata key result", nil).Fatal()
+			return
+		}
+		if *expectedVal != *gotValue {
+			failureLog(function, args, startTime, "", "HEAD Object returned unexpected metadata value result", nil).Fatal()
+			return
+		}
+	}
+
+	// 30 entries, 3210 bytes in total
+	putInput = &s3.PutObjectInput{
+		Body:     aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(object + "-too-large"),
+		Metadata: makeMetadata(30),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err == nil {
+		failureLog(function, args, startTime, "", "PUT with too large metadata expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != "MetadataTooLarge" {
+		failureLog(function, args, startTime, "", "PUT with too large metadata returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
@@ -20,15 +20,15 @@
 package main
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"math/rand"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -255,16 +255,7 @@ func testLockingRetentionGovernanceMultipart() {
 		return
 	}
 
-	fileSize := 30 * 1024 * 1024
-	createTestObject(int64(fileSize), object)
-
-	f, err := os.Open(object)
-	if err != nil {
-		failureLog(function, args, startTime, "", "Open testobject failed", err).Fatal()
-		return
-	}
-	defer f.Close()
-	defer os.Remove(object)
+	data := make([]byte, 30*1024*1024)
 
 	defer cleanupBucket(bucket, function, args, startTime)
 
@@ -296,64 +287,13 @@ func testLockingRetentionGovernanceMultipart() {
 			cmui.ObjectLockRetainUntilDate = aws.Time(uploads[i].retentionUntil)
 		}
 
-		multipartUpload, err := s3Client.CreateMultipartUpload(&cmui)
-
+		versionId, err := putMultipartObject(&cmui, data, partSize)
 		if err != nil {
-			failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
-			return
-		}
-
-		filePart := make([]byte, partSize)
-		partCount := fileSize / partSize
-		parts := make([]*string, partCount)
-		for j := 0; j < partCount; j++ {
-			_, err := f.ReadAt(filePart, int64(partSize*j))
-			if err != nil {
-				failureLog(function, args, startTime, "", "ReadAt failed", err).Fatal()
-				return
-			}
-			r := bytes.NewReader(filePart)
-
-			result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
-				Bucket:     aws.String(bucket),
-				Key:        aws.String(object),
-				UploadId:   multipartUpload.UploadId,
-				PartNumber: aws.Int64(int64(j + 1)),
-				Body:       aws.ReadSeekCloser(r),
-			})
-			if errUpload != nil {
-				_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
-					Bucket:   aws.String(bucket),
-					Key:      aws.String(object),
-					UploadId: multipartUpload.UploadId,
-				})
-				failureLog(function, args, startTime, "", "UploadPart API failed for", errUpload).Fatal()
-				return
-			}
-			parts[j] = result.ETag
-		}
-
-		completedParts := make([]*s3.CompletedPart, len(parts))
-		for i, part := range parts {
-			completedParts[i] = &s3.CompletedPart{
-				ETag:       part,
-				PartNumber: aws.Int64(int64(i + 1)),
-			}
-		}
-
-		output, err := s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(object),
-			MultipartUpload: &s3.CompletedMultipartUpload{
-				Parts: completedParts},
-			UploadId: multipartUpload.UploadId,
-		})
-		if err != nil {
-			failureLog(function, args, startTime, "", "CompleteMultipartUpload is expected to succeed but failed", errors.New("expected nil")).Fatal()
+			failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
 			return
 		}
 
-		uploads[i].versionId = *output.VersionId
+		uploads[i].versionId = versionId
 	}
 
 	// In all cases, we can remove an object by creating a delete marker
@@ -765,16 +705,7 @@ func testPutGetDeleteLockingRetentionMultipart(function, retentionMode string) {
 		return
 	}
 
-	fileSize := 30 * 1024 * 1024
-	createTestObject(int64(fileSize), object)
-
-	f, err := os.Open(object)
-	if err != nil {
-		failureLog(function, args, startTime, "", "Open testobject failed", err).Fatal()
-		return
-	}
-	defer f.Close()
-	defer os.Remove(object)
+	data := make([]byte, 30*1024*1024)
 
 	defer cleanupBucket(bucket, function, args, startTime)
 
@@ -784,70 +715,17 @@ func testPutGetDeleteLockingRetentionMultipart(function, retentionMode string) {
 	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
 
 	// Upload version and save the version ID
-	multipartUpload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+	versionId, err := putMultipartObject(&s3.CreateMultipartUploadInput{
 		Bucket:                    aws.String(bucket),
 		Key:                       aws.String(object),
 		ObjectLockMode:            aws.String(retentionMode),
 		ObjectLockRetainUntilDate: aws.Time(oneMinuteRetention),
-	})
-
-	if err != nil {
-		failureLog(function, args, startTime, "", "CreateMultipartupload API failed", err).Fatal()
-		return
-	}
-
-	filePart := make([]byte, partSize)
-	partCount := fileSize / partSize
-	parts := make([]*string, partCount)
-	for j := 0; j < partCount; j++ {
-		_, err := f.ReadAt(filePart, int64(partSize*j))
-		if err != nil {
-			failureLog(function, args, startTime, "", "ReadAt failed", err).Fatal()
-			return
-		}
-		r := bytes.NewReader(filePart)
-
-		result, errUpload := s3Client.UploadPart(&s3.UploadPartInput{
-			Bucket:     aws.String(bucket),
-			Key:        aws.String(object),
-			UploadId:   multipartUpload.UploadId,
-			PartNumber: aws.Int64(int64(j + 1)),
-			Body:       aws.ReadSeekCloser(r),
-		})
-		if errUpload != nil {
-			_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
-				Bucket:   aws.String(bucket),
-				Key:      aws.String(object),
-				UploadId: multipartUpload.UploadId,
-			})
-			failureLog(function, args, startTime, "", "UploadPart API failed for", errUpload).Fatal()
-			return
-		}
-		parts[j] = result.ETag
-	}
-
-	completedParts := make([]*s3.CompletedPart, len(parts))
-	for i, part := range parts {
-		completedParts[i] = &s3.CompletedPart{
-			ETag:       part,
-			PartNumber: aws.Int64(int64(i + 1)),
-		}
-	}
-
-	output, err := s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(object),
-		MultipartUpload: &s3.CompletedMultipartUpload{
-			Parts: completedParts},
-		UploadId: multipartUpload.UploadId,
-	})
+	}, data, partSize)
 	if err != nil {
-		failureLog(function, args, startTime, "", "CompleteMultipartUpload is expected to succeed but failed", errors.New("expected nil")).Fatal()
+		failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
 		return
 	}
 
-	versionId := *output.VersionId
-
 	// Increase retention until date
 	putRetentionInput := &s3.PutObjectRetentionInput{
 		Bucket:    aws.String(bucket),
@@ -935,3 +813,399 @@ func testPutGetDeleteLockingRetentionMultipart(function, retentionMode string) {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test bucket default retention applied to objects uploaded with multipart
+func testDefaultRetentionMultipart() {
+	startTime := time.Now()
+	function := "testDefaultRetentionMultipart"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+
+	data := make([]byte, 10*1024*1024)
+
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	_, err = s3Client.PutObjectLockConfiguration(&s3.PutObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+		ObjectLockConfiguration: &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String("Enabled"),
+			Rule: &s3.ObjectLockRule{
+				DefaultRetention: &s3.DefaultRetention{
+					Mode: aws.String("GOVERNANCE"),
+					Days: aws.Int64(1),
+				},
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotImplemented" {
+			ignoreLog(function, args, startTime, "PutObjectLockConfiguration is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", fmt.Sprintf("PutObjectLockConfiguration expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	partSize := 5 * 1024 * 1024 // Set part size to 5 MB (minimum size for a part)
+
+	// Upload without any explicit retention
+	versionId, err := putMultipartObject(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}, data, partSize)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("Multipart upload expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	// The assembled object should inherit the bucket default retention
+	retentionOutput, err := s3Client.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GetObjectRetention expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	if retentionOutput.Retention == nil || aws.StringValue(retentionOutput.Retention.Mode) != "GOVERNANCE" {
+		failureLog(function, args, startTime, "", "Unexpected retention mode", nil).Fatal()
+		return
+	}
+
+	if !aws.TimeValue(retentionOutput.Retention.RetainUntilDate).After(time.Now()) {
+		failureLog(function, args, startTime, "", "Unexpected until retention date", nil).Fatal()
+		return
+	}
+
+	// Deleting the version without governance bypass should fail
+	_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: aws.String(versionId),
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "DELETE expected to fail but succeed instead", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test retention until a date in the past is rejected
+func testRetentionPastDate() {
+	startTime := time.Now()
+	function := "testRetentionPastDate"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	pastRetention := time.Now().UTC().Add(-time.Hour)
+
+	// PUT with a retention date in the past
+	putInput := &s3.PutObjectInput{
+		Body:                      aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(object),
+		ObjectLockMode:            aws.String("GOVERNANCE"),
+		ObjectLockRetainUntilDate: aws.Time(pastRetention),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err == nil {
+		failureLog(function, args, startTime, "", "PUT with past retention date expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	// MinIO reports InvalidRequest where AWS S3 reports InvalidArgument
+	aerr, ok := err.(awserr.Error)
+	if !ok || (aerr.Code() != "InvalidArgument" && aerr.Code() != "InvalidRequest") {
+		failureLog(function, args, startTime, "", "PUT with past retention date returned unexpected error", err).Fatal()
+		return
+	}
+
+	// PutObjectRetention with a retention date in the past
+	putInput = &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	}
+	output, err := s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	putRetentionInput := &s3.PutObjectRetentionInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(object),
+		VersionId: output.VersionId,
+		Retention: &s3.ObjectLockRetention{
+			Mode:            aws.String("GOVERNANCE"),
+			RetainUntilDate: aws.Time(pastRetention),
+		},
+	}
+	_, err = s3Client.PutObjectRetention(putRetentionInput)
+	if err == nil {
+		failureLog(function, args, startTime, "", "PutObjectRetention with past retention date expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok = err.(awserr.Error)
+	if !ok || (aerr.Code() != "InvalidArgument" && aerr.Code() != "InvalidRequest") {
+		failureLog(function, args, startTime, "", "PutObjectRetention with past retention date returned unexpected error", err).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test deleting retained versions with and without governance bypass
+func testObjectLockRetention() {
+	startTime := time.Now()
+	function := "testObjectLockRetention"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	type uploadedObject struct {
+		retention      string
+		retentionUntil time.Time
+		versionId      string
+	}
+
+	uploads := []uploadedObject{
+		{retention: "GOVERNANCE", retentionUntil: time.Now().UTC().Add(time.Hour)},
+		{retention: "COMPLIANCE", retentionUntil: time.Now().UTC().Add(time.Minute)},
+	}
+
+	// Upload versions and save their version IDs
+	for i := range uploads {
+		putInput := &s3.PutObjectInput{
+			Body:                      aws.ReadSeekCloser(strings.NewReader("content")),
+			Bucket:                    aws.String(bucket),
+			Key:                       aws.String(object),
+			ObjectLockMode:            aws.String(uploads[i].retention),
+			ObjectLockRetainUntilDate: aws.Time(uploads[i].retentionUntil),
+		}
+		output, err := s3Client.PutObject(putInput)
+		if err != nil {
+			failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		uploads[i].versionId = *output.VersionId
+	}
+
+	// Second client
+	creds := credentials.NewStaticCredentials("test", "test", "")
+	newSession, err := session.NewSession()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("NewSession expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	s3Config := s3Client.Config
+	s3Config.Credentials = creds
+	s3ClientTest := s3.New(newSession, &s3Config)
+
+	for i := range uploads {
+		// Check with a second client: object-handlers.go > GetObjectRetentionHandler > checkRequestAuthType
+		getRetentionInput := &s3.GetObjectRetentionInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(object),
+			VersionId: aws.String(uploads[i].versionId),
+		}
+		// The Access Key Id you provided does not exist in our records.
+		_, err = s3ClientTest.GetObjectRetention(getRetentionInput)
+		if err == nil {
+			failureLog(function, args, startTime, "", "GetObjectRetention expected to fail but succeeded", nil).Fatal()
+			return
+		}
+
+		// Check with a second client: object-handlers.go > PutObjectRetentionHandler > checkRequestAuthType
+		putRetentionInput := &s3.PutObjectRetentionInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(object),
+			VersionId: aws.String(uploads[i].versionId),
+			Retention: &s3.ObjectLockRetention{
+				Mode:            aws.String(uploads[i].retention),
+				RetainUntilDate: aws.Time(uploads[i].retentionUntil.Add(time.Hour)),
+			},
+		}
+		// The Access Key Id you provided does not exist in our records.
+		_, err = s3ClientTest.PutObjectRetention(putRetentionInput)
+		if err == nil {
+			failureLog(function, args, startTime, "", "PutObjectRetention expected to fail but succeeded", nil).Fatal()
+			return
+		}
+	}
+
+	for i := range uploads {
+		// Delete without governance bypass always fails
+		deleteInput := &s3.DeleteObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(object),
+			VersionId: aws.String(uploads[i].versionId),
+		}
+		_, err = s3Client.DeleteObject(deleteInput)
+		if err == nil {
+			failureLog(function, args, startTime, "", "DELETE expected to fail but succeed instead", nil).Fatal()
+			return
+		}
+
+		// Governance bypass only lifts GOVERNANCE retention
+		deleteInput.BypassGovernanceRetention = aws.Bool(true)
+		_, err = s3Client.DeleteObject(deleteInput)
+		if err != nil && uploads[i].retention == "GOVERNANCE" {
+			failureLog(function, args, startTime, "", fmt.Sprintf("DELETE with governance bypass expected to succeed but got %v", err), err).Fatal()
+			return
+		}
+		if err == nil && uploads[i].retention == "COMPLIANCE" {
+			failureLog(function, args, startTime, "", "DELETE with governance bypass expected to fail but succeed instead", nil).Fatal()
+			return
+		}
+	}
+
+	successLogger(function, args, startTime).Info()
+}
+
+// Test that governance bypass only applies to versioned deletes
+func testBypassRequiresVersionId() {
+	startTime := time.Now()
+	function := "testBypassRequiresVersionId"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String(bucket),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotImplemented: A header you provided implies functionality that is not implemented") {
+			ignoreLog(function, args, startTime, "Versioning is not implemented").Info()
+			return
+		}
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:                      aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(object),
+		ObjectLockMode:            aws.String("GOVERNANCE"),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().UTC().Add(time.Hour)),
+	}
+	output, err := s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	versionId := aws.StringValue(output.VersionId)
+
+	// Without a version id the delete only adds a delete marker
+	deleteOutput, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(object),
+		BypassGovernanceRetention: aws.Bool(true),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("DELETE expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if !aws.BoolValue(deleteOutput.DeleteMarker) {
+		failureLog(function, args, startTime, "", "DELETE without version id expected to create a delete marker", nil).Fatal()
+		return
+	}
+
+	listOutput, err := s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(listOutput.DeleteMarkers) != 1 || len(listOutput.Versions) != 1 || aws.StringValue(listOutput.Versions[0].VersionId) != versionId {
+		failureLog(function, args, startTime, "", "ListObjectVersions expected the retained version and one delete marker", nil).Fatal()
+		return
+	}
+
+	// With a version id the bypass lifts the governance retention
+	_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(object),
+		VersionId:                 aws.String(versionId),
+		BypassGovernanceRetention: aws.Bool(true),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("DELETE with bypass expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	listOutput, err = s3Client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("ListObjectVersions expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if len(listOutput.Versions) != 0 {
+		failureLog(function, args, startTime, "", "ListObjectVersions returned a version deleted with bypass", nil).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
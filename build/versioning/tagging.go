@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -201,3 +202,112 @@ func testTagging() {
 
 	successLogger(function, args, startTime).Info()
 }
+
+// Test the limit of 10 tags per object
+func testTagLimit() {
+	startTime := time.Now()
+	function := "testTagLimit"
+	bucket := randString(60, rand.NewSource(time.Now().UnixNano()), "versioning-test-")
+	object := "testObject"
+	args := map[string]interface{}{
+		"bucketName": bucket,
+		"objectName": object,
+	}
+
+	_, err := s3Client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", "CreateBucket failed", err).Fatal()
+		return
+	}
+	defer cleanupBucket(bucket, function, args, startTime)
+
+	putInput := &s3.PutObjectInput{
+		Body:    aws.ReadSeekCloser(strings.NewReader("content")),
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(object),
+		Tagging: aws.String("type=text"),
+	}
+	_, err = s3Client.PutObject(putInput)
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	makeTags := func(n int) []*s3.Tag {
+		tags := make([]*s3.Tag, n)
+		for i := range tags {
+			tags[i] = &s3.Tag{Key: aws.String(fmt.Sprintf("key%d", i)), Value: aws.String(fmt.Sprintf("value%d", i))}
+		}
+		return tags
+	}
+	getTags := func() (map[string]string, error) {
+		output, err := s3Client.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+		})
+		if err != nil {
+			return nil, err
+		}
+		tags := make(map[string]string, len(output.TagSet))
+		for _, tag := range output.TagSet {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return tags, nil
+	}
+
+	// More than 10 tags are rejected
+	_, err = s3Client.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(object),
+		Tagging: &s3.Tagging{TagSet: makeTags(11)},
+	})
+	if err == nil {
+		failureLog(function, args, startTime, "", "PUT Object tagging with 11 tags expected to fail but succeeded", nil).Fatal()
+		return
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok || (aerr.Code() != "BadRequest" && aerr.Code() != "InvalidTag") {
+		failureLog(function, args, startTime, "", "PUT Object tagging with 11 tags returned unexpected error", err).Fatal()
+		return
+	}
+
+	tags, err := getTags()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GET Object tagging expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	if !reflect.DeepEqual(tags, map[string]string{"type": "text"}) {
+		failureLog(function, args, startTime, "", "Rejected PUT Object tagging changed the object tags", fmt.Errorf("got %v", tags)).Fatal()
+		return
+	}
+
+	// Exactly 10 tags are accepted
+	tagSet := makeTags(10)
+	_, err = s3Client.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(object),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("PUT Object tagging with 10 tags expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+
+	tags, err = getTags()
+	if err != nil {
+		failureLog(function, args, startTime, "", fmt.Sprintf("GET Object tagging expected to succeed but got %v", err), err).Fatal()
+		return
+	}
+	expected := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		expected[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		failureLog(function, args, startTime, "", "GET Object tagging returned unexpected tags", fmt.Errorf("want %v, got %v", expected, tags)).Fatal()
+		return
+	}
+
+	successLogger(function, args, startTime).Info()
+}
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -27,8 +28,11 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -62,6 +66,112 @@ type errorResponse struct {
 	Headers http.Header `xml:"-" json:"-"`
 }
 
+// TestResult describes the outcome of a single test case
+type TestResult struct {
+	Name     string
+	Function string
+	Args     map[string]interface{}
+	Duration time.Duration
+	Status   string
+	Alert    string
+	Message  string
+	Err      error
+}
+
+// ResultSink receives every test result reported through the loggers,
+// in addition to the JSON lines written by mintJSONFormatter
+type ResultSink interface {
+	Record(result TestResult)
+}
+
+// multiSink fans a result out to all of its sinks in order
+type multiSink []ResultSink
+
+func (m multiSink) Record(result TestResult) {
+	for _, sink := range m {
+		sink.Record(result)
+	}
+}
+
+// Sinks fed by resultHook, register new ones with addResultSink
+var resultSinks multiSink
+
+func addResultSink(sink ResultSink) {
+	resultSinks = append(resultSinks, sink)
+}
+
+// resultHook feeds every test result written as a JSON line to
+// resultSinks, so that the sinks see exactly what the mint log reports
+type resultHook struct{}
+
+func (resultHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (resultHook) Fire(entry *log.Entry) error {
+	status, _ := entry.Data["status"].(string)
+	if entry.Data["name"] != "versioning" || (status != PASS && status != FAIL && status != "NA") {
+		return nil
+	}
+	result := TestResult{Name: "versioning", Status: status}
+	result.Function, _ = entry.Data["function"].(string)
+	result.Args, _ = entry.Data["args"].(map[string]interface{})
+	if duration, ok := entry.Data["duration"].(int64); ok {
+		result.Duration = time.Duration(duration) * time.Millisecond
+	}
+	result.Alert, _ = entry.Data["alert"].(string)
+	result.Message, _ = entry.Data["message"].(string)
+	result.Err, _ = entry.Data["error"].(error)
+	resultSinks.Record(result)
+	return nil
+}
+
+// summarySink counts test results by status, it is safe for concurrent use
+type summarySink struct {
+	mu      sync.Mutex
+	passed  int
+	failed  int
+	skipped int
+	failure *TestResult
+}
+
+func (s *summarySink) Record(result TestResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch result.Status {
+	case PASS:
+		s.passed++
+	case FAIL:
+		s.failed++
+		s.failure = &result
+	default:
+		s.skipped++
+	}
+}
+
+// log the aggregated results of the whole run as a single line
+func (s *summarySink) logger(startTime time.Time) *log.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := PASS
+	if s.failed > 0 {
+		status = FAIL
+	}
+	fields := log.Fields{
+		"name": "versioning-summary", "passed": s.passed, "failed": s.failed, "skipped": s.skipped,
+		"duration": time.Since(startTime).Nanoseconds() / 1000000, "status": status,
+	}
+	// mint.sh only reports the last line, so repeat what made the run fail
+	if s.failure != nil {
+		fields["function"] = s.failure.Function
+		fields["message"] = s.failure.Message
+		if s.failure.Err != nil {
+			fields["error"] = s.failure.Err
+		}
+	}
+	return log.WithFields(fields)
+}
+
 type mintJSONFormatter struct{}
 
 func (f *mintJSONFormatter) Format(entry *log.Entry) ([]byte, error) {
@@ -93,14 +203,25 @@ func successLogger(function string, args map[string]interface{}, startTime time.
 	return log.WithFields(fields)
 }
 
+// log test runs planned with LIST_TESTS
+func plannedLog(function string) *log.Entry {
+	fields := log.Fields{"name": "versioning", "function": function, "status": "PLANNED"}
+	return log.WithFields(fields)
+}
+
 // log not applicable test runs
 func ignoreLog(function string, args map[string]interface{}, startTime time.Time, alert string) *log.Entry {
+	return skipLog(function, args, startTime, strings.Split(alert, " ")[0]+" is NotImplemented")
+}
+
+// log test runs skipped for a reason other than missing server support
+func skipLog(function string, args map[string]interface{}, startTime time.Time, alert string) *log.Entry {
 	// calculate the test case duration
 	duration := time.Since(startTime)
 	// log with the fields as per mint
 	fields := log.Fields{
 		"name": "versioning", "function": function, "args": args,
-		"duration": duration.Nanoseconds() / 1000000, "status": "NA", "alert": strings.Split(alert, " ")[0] + " is NotImplemented",
+		"duration": duration.Nanoseconds() / 1000000, "status": "NA", "alert": alert,
 	}
 	return log.WithFields(fields)
 }
@@ -144,3 +265,53 @@ func randString(n int, src rand.Source, prefix string) string {
 	}
 	return prefix + string(b[0:30-len(prefix)])
 }
+
+// putMultipartObject uploads data in parts of partSize bytes to the bucket
+// and key of input, which may also carry object lock settings. The upload
+// is aborted when a part fails. It returns the version ID of the object.
+func putMultipartObject(input *s3.CreateMultipartUploadInput, data []byte, partSize int) (string, error) {
+	multipartUpload, err := s3Client.CreateMultipartUpload(input)
+	if err != nil {
+		return "", err
+	}
+
+	var completedParts []*s3.CompletedPart
+	for offset := 0; offset < len(data); offset += partSize {
+		end := offset + partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		partNumber := aws.Int64(int64(len(completedParts) + 1))
+		result, err := s3Client.UploadPart(&s3.UploadPartInput{
+			Bucket:     input.Bucket,
+			Key:        input.Key,
+			UploadId:   multipartUpload.UploadId,
+			PartNumber: partNumber,
+			Body:       aws.ReadSeekCloser(bytes.NewReader(data[offset:end])),
+		})
+		if err != nil {
+			_, _ = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   input.Bucket,
+				Key:      input.Key,
+				UploadId: multipartUpload.UploadId,
+			})
+			return "", err
+		}
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       result.ETag,
+			PartNumber: partNumber,
+		})
+	}
+
+	output, err := s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts},
+		UploadId: multipartUpload.UploadId,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.VersionId), nil
+}